@@ -31,6 +31,10 @@ type DiffStats struct {
 	TimeSpentSorting  time.Duration
 	TimeSpentScanning time.Duration
 	BiggestAdd        int64
+
+	// WindowsProcessed is only set by DoStreaming: the number of
+	// windows new was split into.
+	WindowsProcessed int
 }
 
 // DiffContext holds settings for the diff process, along with some
@@ -50,6 +54,26 @@ type DiffContext struct {
 	// MeasureParallelOverhead prints some stats on the overhead of parallel suffix sorting
 	MeasureParallelOverhead bool
 
+	// SuffixSortAlgorithm picks how the suffix array of the old file
+	// gets built. Defaults to SuffixSortGosaca, which is bound by
+	// MaxFileSize; set it to SuffixSortSAIS to lift that limit.
+	SuffixSortAlgorithm SuffixSortAlgorithm
+
+	// WindowSize is the size of each window DoStreaming splits new
+	// into. Defaults to DefaultWindowSize. Ignored by Do.
+	WindowSize int64
+
+	// OverlapSize is how far DoStreaming reads into old on either
+	// side of the bytes matching the current window of new, so
+	// matches straddling a window boundary aren't lost. Defaults to
+	// DefaultOverlapSize. Ignored by Do.
+	OverlapSize int64
+
+	// SuffixArrayCache, if set, lets Do skip rebuilding the suffix
+	// array of old when it's seen that exact content before - useful
+	// when diffing the same baseline against many targets.
+	SuffixArrayCache SuffixArrayCache
+
 	Stats *DiffStats
 
 	db bytes.Buffer
@@ -92,9 +116,43 @@ func (ctx *DiffContext) Do(old, new io.Reader, writeMessage WriteMessageFunc, co
 	var lenf int
 	startTime := time.Now()
 
-	I := make([]int, len(obuf)+1)
-	ws := &gosaca.WorkSpace{}
-	ws.ComputeSuffixArray(obuf, I[:len(obuf)])
+	var cacheKey SuffixArrayCacheKey
+	var sa suffixArray
+	if ctx.SuffixArrayCache != nil {
+		cacheKey = SuffixArrayCacheKeyFor(obuf, ctx.SuffixSortAlgorithm)
+		cached, ok, err := ctx.SuffixArrayCache.Get(cacheKey)
+		if err != nil {
+			consumer.Debugf("bsdiff: suffix array cache lookup failed: %s", err.Error())
+		} else if ok {
+			sa = cached
+		}
+	}
+
+	var I []int
+	if sa != nil {
+		I = sa.ToIntSlice()
+	} else {
+		switch ctx.SuffixSortAlgorithm {
+		case SuffixSortSAIS:
+			sa = computeSuffixArraySAIS(obuf)
+		default:
+			if int64(obuflen) > MaxFileSize {
+				return fmt.Errorf("bsdiff: old file is %s, larger than MaxFileSize (%s); use SuffixSortSAIS to lift this limit",
+					humanize.IBytes(uint64(obuflen)), humanize.IBytes(uint64(MaxFileSize)))
+			}
+			gI := make([]int, obuflen+1)
+			ws := &gosaca.WorkSpace{}
+			ws.ComputeSuffixArray(obuf, gI[:obuflen])
+			sa = int32SuffixArray(intSliceToInt32(gI))
+		}
+		I = sa.ToIntSlice()
+
+		if ctx.SuffixArrayCache != nil {
+			if err := ctx.SuffixArrayCache.Put(cacheKey, sa); err != nil {
+				consumer.Debugf("bsdiff: suffix array cache write failed: %s", err.Error())
+			}
+		}
+	}
 
 	if ctx.Stats != nil {
 		ctx.Stats.TimeSpentSorting += time.Since(startTime)
@@ -0,0 +1,321 @@
+package bsdiff
+
+import "math"
+
+// sais.go implements the SA-IS (Induced Sorting) suffix array
+// construction algorithm described by Nong, Zhang and Chen in
+// "Linear Suffix Array Construction by Almost Pure Induced-Sorting"
+// (2009) -- the same algorithm behind Go's own index/suffixarray
+// package. It runs in O(n) time and O(n) extra space, with no
+// practical size limit, unlike the gosaca-based doubling sort Do
+// defaults to.
+
+// SuffixSortAlgorithm selects which suffix sort DiffContext.Do uses to
+// build the suffix array of the old file.
+type SuffixSortAlgorithm int
+
+const (
+	// SuffixSortGosaca is the default: a qsufsort-derived doubling
+	// sort. It's fast for small-to-medium inputs, but its doubling
+	// passes over a single []int balloon memory well before the 2GB
+	// mark, hence MaxFileSize.
+	SuffixSortGosaca SuffixSortAlgorithm = iota
+
+	// SuffixSortSAIS builds the suffix array with SA-IS instead. It
+	// has no size guard: inputs under 2 GiB are indexed with []int32,
+	// larger ones with []int64, so it's the right choice for diffing
+	// multi-gigabyte game patches.
+	SuffixSortSAIS
+)
+
+// saisIndex is the set of integer widths sais can build a suffix array
+// into.
+type saisIndex interface {
+	~int32 | ~int64
+}
+
+// suffixArray abstracts over int32- and int64-backed suffix arrays so
+// callers don't need to care which width SA-IS picked.
+type suffixArray interface {
+	Len() int
+	At(i int) int64
+
+	// ToIntSlice copies the suffix array into a []int, for handing off
+	// to the existing int-indexed search().
+	ToIntSlice() []int
+}
+
+type int32SuffixArray []int32
+
+func (a int32SuffixArray) Len() int       { return len(a) }
+func (a int32SuffixArray) At(i int) int64 { return int64(a[i]) }
+func (a int32SuffixArray) ToIntSlice() []int {
+	out := make([]int, len(a))
+	for i, v := range a {
+		out[i] = int(v)
+	}
+	return out
+}
+
+type int64SuffixArray []int64
+
+func (a int64SuffixArray) Len() int       { return len(a) }
+func (a int64SuffixArray) At(i int) int64 { return a[i] }
+func (a int64SuffixArray) ToIntSlice() []int {
+	out := make([]int, len(a))
+	for i, v := range a {
+		out[i] = int(v)
+	}
+	return out
+}
+
+// intSliceToInt32 narrows a gosaca-produced []int down to []int32, so
+// it can be wrapped in a suffixArray (and cached) like a SA-IS result.
+// Callers must ensure every value fits, e.g. by checking against
+// MaxFileSize first.
+func intSliceToInt32(xs []int) []int32 {
+	out := make([]int32, len(xs))
+	for i, v := range xs {
+		out[i] = int32(v)
+	}
+	return out
+}
+
+// computeSuffixArraySAIS builds the suffix array of data with SA-IS,
+// choosing an int32-indexed array when data fits in 2 GiB and an
+// int64-indexed one otherwise. The result has len(data)+1 entries, not
+// len(data): search (diff.go, streaming.go) is called with en set to
+// len(data) and indexes I[en], so it needs that trailing slot, the
+// same way the gosaca path's I is over-allocated by one and leaves its
+// last entry at its zero value rather than ever writing to it.
+func computeSuffixArraySAIS(data []byte) suffixArray {
+	// saisBytes sorts data plus its implicit sentinel, so its result
+	// has one more entry than data is long, with the sentinel's own
+	// (empty) suffix first - drop it to get the suffix array of data
+	// itself, then pad back out to len(data)+1 to match what search
+	// expects.
+	if int64(len(data)) < int64(math.MaxInt32) {
+		full := saisBytes[int32](data)
+		sa := make([]int32, len(data)+1)
+		copy(sa, full[1:])
+		return int32SuffixArray(sa)
+	}
+	full := saisBytes[int64](data)
+	sa := make([]int64, len(data)+1)
+	copy(sa, full[1:])
+	return int64SuffixArray(sa)
+}
+
+// saisBytes runs SA-IS over data, mapping each byte to the symbol
+// range [1, 256] and appending an implicit sentinel of 0, which sorts
+// before every real symbol and is unique.
+func saisBytes[T saisIndex](data []byte) []T {
+	s := make([]int32, len(data)+1)
+	for i, b := range data {
+		s[i] = int32(b) + 1
+	}
+	// s[len(data)] is left at 0: the sentinel.
+	return sais[T](s, 256)
+}
+
+// sais builds the suffix array of s, an alphabet-[0,K] string whose
+// last symbol is a unique sentinel (0) smaller than every other symbol
+// in s. Every position and count that can grow with len(s) - n itself,
+// the working array, bucket heads/tails, LMS positions and names - is
+// carried in T, not int32, so a T=int64 instantiation can address
+// suffix arrays past the 2 GiB / int32 boundary without wrapping. Only
+// the symbol alphabet (s's element type and K, bounded by the 256 byte
+// values plus the sentinel) stays int32: the recursion's summary
+// string reuses that same bound, which holds as long as a text has
+// fewer than 2^31 distinct LMS substrings - true of any real file at
+// the sizes this algorithm targets.
+func sais[T saisIndex](s []int32, K int32) []T {
+	n := T(len(s))
+	sa := make([]T, n)
+	if n == 1 {
+		return sa
+	}
+
+	// Classify each position as S-type (its suffix is smaller than
+	// its successor's) or L-type (larger), scanning right to left.
+	// The sentinel is always S-type.
+	isS := make([]bool, n)
+	isS[n-1] = true
+	for i := n - 2; i >= 0; i-- {
+		switch {
+		case s[i] < s[i+1]:
+			isS[i] = true
+		case s[i] > s[i+1]:
+			isS[i] = false
+		default:
+			isS[i] = isS[i+1]
+		}
+	}
+	isLMS := func(i T) bool {
+		return i > 0 && isS[i] && !isS[i-1]
+	}
+
+	bucketSizes := make([]T, K+1)
+	for _, c := range s {
+		bucketSizes[c]++
+	}
+	bucketHeads := func() []T {
+		heads := make([]T, K+1)
+		var sum T
+		for c, size := range bucketSizes {
+			heads[c] = sum
+			sum += size
+		}
+		return heads
+	}
+	bucketTails := func() []T {
+		tails := make([]T, K+1)
+		var sum T
+		for c, size := range bucketSizes {
+			sum += size
+			tails[c] = sum
+		}
+		return tails
+	}
+
+	induce := func(lms []T) {
+		for i := range sa {
+			sa[i] = -1
+		}
+
+		// Seed: place LMS suffixes at the tails of their buckets, in
+		// reverse order so equal-bucket entries end up in the order
+		// lms gave them.
+		tails := bucketTails()
+		for i := len(lms) - 1; i >= 0; i-- {
+			j := lms[i]
+			c := s[j]
+			tails[c]--
+			sa[tails[c]] = j
+		}
+
+		// Induce L-type positions left to right, at bucket heads.
+		heads := bucketHeads()
+		for i := T(0); i < n; i++ {
+			j := sa[i]
+			if j <= 0 {
+				continue
+			}
+			if !isS[j-1] {
+				c := s[j-1]
+				sa[heads[c]] = j - 1
+				heads[c]++
+			}
+		}
+
+		// Induce S-type positions right to left, at bucket tails.
+		tails = bucketTails()
+		for i := n - 1; i >= 0; i-- {
+			j := sa[i]
+			if j <= 0 {
+				continue
+			}
+			if isS[j-1] {
+				c := s[j-1]
+				tails[c]--
+				sa[tails[c]] = j - 1
+			}
+		}
+	}
+
+	var lmsPositions []T
+	for i := T(0); i < n; i++ {
+		if isLMS(i) {
+			lmsPositions = append(lmsPositions, i)
+		}
+	}
+
+	// First pass: seed with LMS positions in text order, which is
+	// enough to induce a rough ordering we can name LMS substrings
+	// from. Their exact order gets fixed up below.
+	induce(lmsPositions)
+
+	lmsInSAOrder := make([]T, 0, len(lmsPositions))
+	for _, j := range sa {
+		if isLMS(j) {
+			lmsInSAOrder = append(lmsInSAOrder, j)
+		}
+	}
+
+	lmsSubstrEnd := func(i T) T {
+		for j := i + 1; j < n; j++ {
+			if isLMS(j) {
+				return j
+			}
+		}
+		return n - 1
+	}
+	sameLMSSubstring := func(a, b T) bool {
+		aEnd, bEnd := lmsSubstrEnd(a), lmsSubstrEnd(b)
+		if aEnd-a != bEnd-b {
+			return false
+		}
+		for d := T(0); a+d <= aEnd; d++ {
+			if s[a+d] != s[b+d] || isS[a+d] != isS[b+d] {
+				return false
+			}
+		}
+		return true
+	}
+
+	// Names start at 1, not 0: the summary string below appends its
+	// own sentinel of 0, which must stay strictly smaller than every
+	// real name.
+	names := make([]T, n)
+	for i := range names {
+		names[i] = -1
+	}
+	var name T
+	if len(lmsInSAOrder) > 0 {
+		name = 1
+		names[lmsInSAOrder[0]] = name
+		for i := 1; i < len(lmsInSAOrder); i++ {
+			if !sameLMSSubstring(lmsInSAOrder[i-1], lmsInSAOrder[i]) {
+				name++
+			}
+			names[lmsInSAOrder[i]] = name
+		}
+	}
+	numNames := name
+
+	// Build the summary string: one symbol per LMS position, in text
+	// order, naming its LMS substring, plus its own sentinel. Names
+	// are narrowed to int32 here - see the doc comment above on why
+	// that's safe at these sizes.
+	summary := make([]int32, len(lmsPositions)+1)
+	for i, j := range lmsPositions {
+		summary[i] = int32(names[j])
+	}
+
+	var orderedLMS []T
+	if numNames == T(len(lmsPositions)) {
+		// Every LMS substring is already unique: reading off the
+		// summary string by name (shifted back down by the +1 above)
+		// gives the final LMS order directly.
+		orderedLMS = make([]T, len(lmsPositions))
+		for i, j := range lmsPositions {
+			orderedLMS[summary[i]-1] = j
+		}
+	} else {
+		// Otherwise recurse on the summary string to resolve ties,
+		// at the same index width T so the recursion never narrows
+		// back down to int32 on a large instantiation.
+		summarySA := sais[T](summary, int32(numNames))
+		orderedLMS = make([]T, 0, len(lmsPositions))
+		for _, i := range summarySA {
+			if i < T(len(lmsPositions)) {
+				orderedLMS = append(orderedLMS, lmsPositions[i])
+			}
+		}
+	}
+
+	// Final pass: induce using the now fully-sorted LMS order.
+	induce(orderedLMS)
+
+	return sa
+}
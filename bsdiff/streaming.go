@@ -0,0 +1,239 @@
+package bsdiff
+
+import (
+	"fmt"
+	"io"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/itchio/wharf/state"
+	"github.com/jgallagher/gosaca"
+)
+
+// DefaultWindowSize is the default size of each window DoStreaming
+// slices new into, chosen to line up with MaxMessageSize so every
+// window's control messages stay small enough to stream.
+const DefaultWindowSize int64 = 64 * 1024 * 1024
+
+// DefaultOverlapSize is how far DoStreaming reads into old on either
+// side of a window of new, by default. It needs to be at least as
+// large as the longest match you expect to find straddling a window
+// boundary.
+const DefaultOverlapSize int64 = 1 * 1024 * 1024
+
+// DoStreaming is like Do, but never holds the whole of old or new in
+// memory at once. It walks new window by window (WindowSize bytes at
+// a time, or DefaultWindowSize), and for each window reads the
+// matching byte range of old - padded by OverlapSize on either side so
+// matches that straddle a window boundary are still found - builds a
+// suffix array over that slice alone, and streams control messages
+// out through writeMessage as it goes.
+//
+// Peak memory therefore grows with WindowSize + 2*OverlapSize (the
+// window of new, the slice of old and that slice's suffix array), not
+// with how large old and new are, which is what lets this patch
+// multi-gigabyte assets without ever touching MaxFileSize.
+func (ctx *DiffContext) DoStreaming(old, new io.ReaderAt, oldSize, newSize int64, writeMessage WriteMessageFunc, consumer *state.Consumer) error {
+	windowSize := ctx.WindowSize
+	if windowSize <= 0 {
+		windowSize = DefaultWindowSize
+	}
+	overlapSize := ctx.OverlapSize
+	if overlapSize <= 0 {
+		overlapSize = DefaultOverlapSize
+	}
+
+	consumer.ProgressLabel(fmt.Sprintf("Scanning %s in %s windows...",
+		humanize.IBytes(uint64(newSize)), humanize.IBytes(uint64(windowSize))))
+
+	bsdc := &Control{}
+
+	// lastOldPos is the position in old, in absolute terms, right
+	// after the end of the last copy we emitted. It's carried across
+	// windows so the first Seek of a new window is still relative to
+	// where the previous window left off, rather than to 0.
+	var lastOldPos int64
+	var windows int
+
+	for windowStart := int64(0); windowStart < newSize; windowStart += windowSize {
+		windowEnd := windowStart + windowSize
+		if windowEnd > newSize {
+			windowEnd = newSize
+		}
+
+		oldWindowStart := windowStart - overlapSize
+		if oldWindowStart < 0 {
+			oldWindowStart = 0
+		}
+		oldWindowEnd := windowEnd + overlapSize
+		if oldWindowEnd > oldSize {
+			oldWindowEnd = oldSize
+		}
+
+		nbuf := make([]byte, windowEnd-windowStart)
+		if _, err := io.ReadFull(io.NewSectionReader(new, windowStart, int64(len(nbuf))), nbuf); err != nil {
+			return err
+		}
+
+		obuf := make([]byte, oldWindowEnd-oldWindowStart)
+		if _, err := io.ReadFull(io.NewSectionReader(old, oldWindowStart, int64(len(obuf))), obuf); err != nil {
+			return err
+		}
+		obuflen := len(obuf)
+
+		var I []int
+		if ctx.SuffixSortAlgorithm == SuffixSortSAIS {
+			I = computeSuffixArraySAIS(obuf).ToIntSlice()
+		} else {
+			I = make([]int, obuflen+1)
+			ws := &gosaca.WorkSpace{}
+			ws.ComputeSuffixArray(obuf, I[:obuflen])
+		}
+
+		// Seed lastpos with where, within this window's obuf, the
+		// previous window's last copy ended up - that's exactly what
+		// the overlap is for.
+		lastpos := int(lastOldPos - oldWindowStart)
+		if lastpos < 0 {
+			lastpos = 0
+		}
+		if lastpos > obuflen {
+			lastpos = obuflen
+		}
+
+		newLastOldPos, err := ctx.diffWindow(obuf, nbuf, I, lastpos, oldWindowStart, bsdc, writeMessage)
+		if err != nil {
+			return err
+		}
+		lastOldPos = newLastOldPos
+		windows++
+
+		progress := float64(windowEnd) / float64(newSize)
+		consumer.Progress(progress)
+	}
+
+	if ctx.Stats != nil {
+		ctx.Stats.WindowsProcessed = windows
+	}
+
+	bsdc.Reset()
+	bsdc.Eof = true
+	return writeMessage(bsdc)
+}
+
+// diffWindow runs the core bsdiff matching loop over a single window's
+// worth of obuf/nbuf, writing control messages through writeMessage as
+// it finds them. lastpos is where, within obuf, the previous window's
+// final copy left off (0 for the first window); oldBase is obuf[0]'s
+// offset within the real old stream, used to translate the window-
+// local Seek into a value that still makes sense given the previous
+// window's absolute position. It returns the absolute old-stream
+// position where this window's last copy ended.
+func (ctx *DiffContext) diffWindow(obuf, nbuf []byte, I []int, lastpos int, oldBase int64, bsdc *Control, writeMessage WriteMessageFunc) (int64, error) {
+	obuflen := len(obuf)
+	nbuflen := len(nbuf)
+
+	var lenf int
+	var scan, pos, length int
+	var lastscan, lastoffset int
+	lastoffset = lastpos - lastscan
+
+	for scan < nbuflen {
+		var oldscore int
+		scan += length
+
+		for scsc := scan; scan < nbuflen; scan++ {
+			pos, length = search(I, obuf, nbuf[scan:], 0, obuflen)
+
+			for ; scsc < scan+length; scsc++ {
+				if scsc+lastoffset < obuflen &&
+					obuf[scsc+lastoffset] == nbuf[scsc] {
+					oldscore++
+				}
+			}
+
+			if (length == oldscore && length != 0) || length > oldscore+8 {
+				break
+			}
+
+			if scan+lastoffset < obuflen && obuf[scan+lastoffset] == nbuf[scan] {
+				oldscore--
+			}
+		}
+
+		if length != oldscore || scan == nbuflen {
+			var s, Sf int
+			lenf = 0
+			for i := 0; lastscan+i < scan && lastpos+i < obuflen; {
+				if obuf[lastpos+i] == nbuf[lastscan+i] {
+					s++
+				}
+				i++
+				if s*2-i > Sf*2-lenf {
+					Sf = s
+					lenf = i
+				}
+			}
+
+			lenb := 0
+			if scan < nbuflen {
+				var s, Sb int
+				for i := 1; (scan >= lastscan+i) && (pos >= i); i++ {
+					if obuf[pos-i] == nbuf[scan-i] {
+						s++
+					}
+					if s*2-i > Sb*2-lenb {
+						Sb = s
+						lenb = i
+					}
+				}
+			}
+
+			if lastscan+lenf > scan-lenb {
+				overlap := (lastscan + lenf) - (scan - lenb)
+				s := 0
+				Ss := 0
+				lens := 0
+				for i := 0; i < overlap; i++ {
+					if nbuf[lastscan+lenf-overlap+i] == obuf[lastpos+lenf-overlap+i] {
+						s++
+					}
+					if nbuf[scan-lenb+i] == obuf[pos-lenb+i] {
+						s--
+					}
+					if s > Ss {
+						Ss = s
+						lens = i + 1
+					}
+				}
+
+				lenf += lens - overlap
+				lenb -= lens
+			}
+
+			ctx.db.Reset()
+			ctx.db.Grow(lenf)
+
+			for i := 0; i < lenf; i++ {
+				ctx.db.WriteByte(nbuf[lastscan+i] - obuf[lastpos+i])
+			}
+
+			bsdc.Add = ctx.db.Bytes()
+			bsdc.Copy = nbuf[(lastscan + lenf):(scan - lenb)]
+			bsdc.Seek = int64((pos - lenb) - (lastpos + lenf))
+
+			if err := writeMessage(bsdc); err != nil {
+				return 0, err
+			}
+
+			if ctx.Stats != nil && ctx.Stats.BiggestAdd < int64(lenf) {
+				ctx.Stats.BiggestAdd = int64(lenf)
+			}
+
+			lastscan = scan - lenb
+			lastpos = pos - lenb
+			lastoffset = pos - scan
+		}
+	}
+
+	return oldBase + int64(lastpos), nil
+}
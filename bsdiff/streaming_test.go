@@ -0,0 +1,32 @@
+package bsdiff
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/itchio/wharf/state"
+)
+
+// TestDoStreamingSAISEndToEnd is DoStreaming's half of the regression
+// covered by TestDoSAISEndToEnd in sais_test.go: DoStreaming builds
+// its suffix array the same way Do does (computeSuffixArraySAIS), so
+// it inherited the same out-of-range panic on SuffixSortSAIS before
+// that was fixed, on every window.
+func TestDoStreamingSAISEndToEnd(t *testing.T) {
+	old := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog. "), 50)
+	new := bytes.Repeat([]byte{0xff}, 4)
+
+	ctx := &DiffContext{SuffixSortAlgorithm: SuffixSortSAIS, WindowSize: 64}
+	var nMsgs int
+	err := ctx.DoStreaming(bytes.NewReader(old), bytes.NewReader(new), int64(len(old)), int64(len(new)), func(m proto.Message) error {
+		nMsgs++
+		return nil
+	}, &state.Consumer{})
+	if err != nil {
+		t.Fatalf("DoStreaming returned error: %v", err)
+	}
+	if nMsgs == 0 {
+		t.Fatal("expected at least one control message")
+	}
+}
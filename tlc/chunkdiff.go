@@ -0,0 +1,63 @@
+package tlc
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/itchio/wharf/bsdiff"
+	"github.com/itchio/wharf/state"
+)
+
+// DiffChunks feeds the chunks of newChunks that MatchingChunks
+// couldn't find a hash match for through bsdiff.DiffContext.Do, each
+// against only the old bytes spanned by its neighboring old chunks -
+// the same window MatchingChunks searched, oldChunks[lo:hi] from
+// neighborRange(len(oldChunks), i, neighborWindow) - rather than the
+// whole of old. That's the point of chunking a large container in the
+// first place: the suffix array ctx.Do builds per call is bounded by
+// a chunk window, not by the size of old or new, so it stays well
+// under MaxFileSize even when old and new themselves don't.
+//
+// Composing the resulting per-chunk control message streams into one
+// container-level patch - translating each message's offsets from
+// "relative to this chunk's window" to "relative to the full old and
+// new files" - is left to writeMessage; DiffChunks itself only knows
+// how to carve out the right window per chunk and calls ctx.Do with
+// it.
+func DiffChunks(ctx *bsdiff.DiffContext, old, new io.ReaderAt, oldChunks, newChunks []Chunk, neighborWindow int, writeMessage bsdiff.WriteMessageFunc, consumer *state.Consumer) error {
+	for i, c := range newChunks {
+		lo, hi := neighborRange(len(oldChunks), i, neighborWindow)
+
+		matched := false
+		for _, oc := range oldChunks[lo:hi] {
+			if bytes.Equal(oc.StrongHash, c.StrongHash) {
+				matched = true
+				break
+			}
+		}
+		if matched {
+			continue
+		}
+
+		var windowStart, windowEnd int64
+		if lo < hi {
+			windowStart = oldChunks[lo].Offset
+			windowEnd = oldChunks[hi-1].Offset + oldChunks[hi-1].Size
+		}
+
+		oldWindow := make([]byte, windowEnd-windowStart)
+		if _, err := io.ReadFull(io.NewSectionReader(old, windowStart, int64(len(oldWindow))), oldWindow); err != nil {
+			return err
+		}
+
+		newChunk := make([]byte, c.Size)
+		if _, err := io.ReadFull(io.NewSectionReader(new, c.Offset, c.Size), newChunk); err != nil {
+			return err
+		}
+
+		if err := ctx.Do(bytes.NewReader(oldWindow), bytes.NewReader(newChunk), writeMessage, consumer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
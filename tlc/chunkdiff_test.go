@@ -0,0 +1,79 @@
+package tlc
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/itchio/wharf/bsdiff"
+	"github.com/itchio/wharf/state"
+)
+
+// TestDiffChunks checks that DiffChunks actually calls
+// bsdiff.DiffContext.Do, and so writes control messages, when some
+// chunks have no hash match among their old neighbors - the wiring
+// MatchingChunks alone didn't provide.
+func TestDiffChunks(t *testing.T) {
+	oldData := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog. "), 2000)
+	newData := append(append([]byte{}, oldData...), []byte("extra tail bytes that differ")...)
+	newData[1000] = 'X'
+
+	opts := &ChunkerOpts{Strategy: ChunkFixed, AvgChunkSize: 4096}
+
+	oldPath := writeTempFile(t, oldData)
+	newPath := writeTempFile(t, newData)
+
+	oldChunks, err := ChunkFile(oldPath, int64(len(oldData)), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newChunks, err := ChunkFile(newPath, int64(len(newData)), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, unmatched := MatchingChunks(oldChunks, newChunks, 4)
+	if len(unmatched) == 0 {
+		t.Fatal("expected at least one unmatched chunk")
+	}
+
+	oldFile, err := os.Open(oldPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer oldFile.Close()
+	newFile, err := os.Open(newPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer newFile.Close()
+
+	var nMsgs int
+	ctx := &bsdiff.DiffContext{SuffixSortAlgorithm: bsdiff.SuffixSortSAIS}
+	err = DiffChunks(ctx, oldFile, newFile, oldChunks, newChunks, 4, func(m proto.Message) error {
+		nMsgs++
+		return nil
+	}, &state.Consumer{})
+	if err != nil {
+		t.Fatalf("DiffChunks returned error: %v", err)
+	}
+	if nMsgs == 0 {
+		t.Fatal("expected at least one control message from DiffChunks")
+	}
+}
+
+func writeTempFile(t *testing.T, data []byte) string {
+	t.Helper()
+	f, err := ioutil.TempFile("", "chunkdiff-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(f.Name()) })
+	return f.Name()
+}
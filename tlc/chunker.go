@@ -0,0 +1,300 @@
+package tlc
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+)
+
+// ChunkStrategy selects how (if at all) WalkWithOpts splits large
+// regular files into content-defined chunks recorded on File.Chunks.
+type ChunkStrategy int
+
+const (
+	// ChunkNone disables chunking: every file is recorded as a single
+	// contiguous extent, same as plain Walk.
+	ChunkNone ChunkStrategy = iota
+
+	// ChunkFastCDC splits files at or above ChunkerOpts.MinFileSize
+	// using a FastCDC-style gear hash, cutting on content rather than
+	// fixed offsets so an edit near the start of a file only disturbs
+	// the chunks around it.
+	ChunkFastCDC
+
+	// ChunkFixed splits files at or above ChunkerOpts.MinFileSize into
+	// fixed-size chunks of ChunkerOpts.AvgChunkSize. Cheaper to
+	// compute than ChunkFastCDC, but an insertion shifts every chunk
+	// boundary after it.
+	ChunkFixed
+)
+
+// Chunk describes one content-defined slice of a file.
+type Chunk struct {
+	// Offset is the chunk's start, relative to the start of the file.
+	Offset int64
+
+	// Size is the chunk's length in bytes.
+	Size int64
+
+	// StrongHash identifies the chunk's contents (see
+	// ChunkerOpts.HashFunc), so MatchingChunks can tell which chunks
+	// already exist elsewhere without re-hashing or re-diffing them,
+	// and DiffChunks can skip bsdiff'ing the ones that do.
+	StrongHash []byte
+}
+
+// ChunkerOpts configures content-defined chunking.
+type ChunkerOpts struct {
+	// Strategy selects the chunking algorithm. ChunkNone (the zero
+	// value) disables chunking entirely.
+	Strategy ChunkStrategy
+
+	// MinFileSize is the smallest file ChunkFile will bother chunking;
+	// smaller files are left as a single extent regardless of Strategy.
+	MinFileSize int64
+
+	// MinChunkSize, AvgChunkSize and MaxChunkSize bound the chunks
+	// ChunkFastCDC produces, defaulting to 2/8/32 MiB. ChunkFixed only
+	// honors AvgChunkSize.
+	MinChunkSize int64
+	AvgChunkSize int64
+	MaxChunkSize int64
+
+	// HashFunc computes the StrongHash stored on each Chunk. Defaults
+	// to SHA-256.
+	HashFunc func([]byte) []byte
+}
+
+// gearTable is a fixed pseudo-random table driving the gear hash
+// behind ChunkFastCDC's cut points. It's generated from a fixed seed
+// so chunk boundaries - and therefore which chunks match between two
+// containers - are stable across runs and machines.
+var gearTable = func() (t [256]uint64) {
+	seed := uint64(0x2545f4914f6cdd1d)
+	for i := range t {
+		seed ^= seed << 13
+		seed ^= seed >> 7
+		seed ^= seed << 17
+		t[i] = seed
+	}
+	return t
+}()
+
+// ChunkFile computes File.Chunks for the file at fullPath (size bytes
+// long), according to opts. It returns nil (no error) if opts is nil,
+// opts.Strategy is ChunkNone, or size is below opts.MinFileSize.
+//
+// It streams fullPath rather than buffering it whole: peak memory is
+// bounded by opts.MaxChunkSize (ChunkFastCDC) or opts.AvgChunkSize
+// (ChunkFixed), not by size, the same bound DiffChunks relies on to
+// keep each bsdiff.DiffContext.Do call well under MaxFileSize no
+// matter how large the file itself is.
+func ChunkFile(fullPath string, size int64, opts *ChunkerOpts) ([]Chunk, error) {
+	if opts == nil || opts.Strategy == ChunkNone {
+		return nil, nil
+	}
+	if opts.MinFileSize > 0 && size < opts.MinFileSize {
+		return nil, nil
+	}
+
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	switch opts.Strategy {
+	case ChunkFastCDC:
+		return fastCDCChunks(f, opts)
+	case ChunkFixed:
+		return fixedChunks(f, opts)
+	default:
+		return nil, fmt.Errorf("tlc: unknown chunk strategy %d", opts.Strategy)
+	}
+}
+
+func strongHash(data []byte, opts *ChunkerOpts) []byte {
+	if opts.HashFunc != nil {
+		return opts.HashFunc(data)
+	}
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+// fastCDCChunks splits r on content-defined boundaries: a rolling gear
+// hash is updated one byte at a time between min and max chunk size,
+// and the chunk is cut as soon as the hash's low maskBits bits are all
+// zero, which happens on average every avg bytes.
+//
+// It reads at most max bytes of r into buf at a time, so memory use
+// stays bounded by max regardless of how long r is: once a chunk is
+// cut, any bytes read past it are shifted down to the front of buf and
+// carried into the next iteration instead of being read again.
+func fastCDCChunks(r io.Reader, opts *ChunkerOpts) ([]Chunk, error) {
+	min, avg, max := opts.MinChunkSize, opts.AvgChunkSize, opts.MaxChunkSize
+	if min <= 0 {
+		min = 2 * 1024 * 1024
+	}
+	if avg <= 0 {
+		avg = 8 * 1024 * 1024
+	}
+	if max <= 0 {
+		max = 32 * 1024 * 1024
+	}
+	maskBits := bits.Len64(uint64(avg)) - 1
+	if maskBits < 1 {
+		maskBits = 1
+	}
+	mask := uint64(1)<<uint(maskBits) - 1
+
+	var chunks []Chunk
+	var offset int64
+	buf := make([]byte, max)
+	pending := 0 // buf[:pending] carried over from the previous cut
+	eof := false
+
+	for {
+		if !eof {
+			n, err := io.ReadFull(r, buf[pending:])
+			switch err {
+			case io.ErrUnexpectedEOF, io.EOF:
+				eof = true
+			case nil:
+			default:
+				return nil, err
+			}
+			pending += n
+		}
+		if pending == 0 {
+			break
+		}
+
+		data := buf[:pending]
+		minEnd := int(min)
+		if minEnd > pending {
+			minEnd = pending
+		}
+
+		cut := pending
+		var hash uint64
+		for end := minEnd; end < pending; end++ {
+			hash = (hash << 1) + gearTable[data[end]]
+			if hash&mask == 0 {
+				cut = end + 1
+				break
+			}
+		}
+
+		chunks = append(chunks, Chunk{
+			Offset:     offset,
+			Size:       int64(cut),
+			StrongHash: strongHash(data[:cut], opts),
+		})
+		offset += int64(cut)
+
+		leftover := pending - cut
+		copy(buf[:leftover], data[cut:pending])
+		pending = leftover
+
+		if eof && pending == 0 {
+			break
+		}
+	}
+	return chunks, nil
+}
+
+// fixedChunks splits r into chunks of opts.AvgChunkSize (defaulting to
+// 8 MiB), except possibly the last one, which takes the remainder. It
+// reads one chunk's worth of r into memory at a time.
+func fixedChunks(r io.Reader, opts *ChunkerOpts) ([]Chunk, error) {
+	size := opts.AvgChunkSize
+	if size <= 0 {
+		size = 8 * 1024 * 1024
+	}
+
+	var chunks []Chunk
+	var offset int64
+	buf := make([]byte, size)
+	for {
+		n, err := io.ReadFull(r, buf)
+		switch err {
+		case io.ErrUnexpectedEOF, io.EOF:
+		case nil:
+		default:
+			return nil, err
+		}
+		if n == 0 {
+			break
+		}
+
+		chunks = append(chunks, Chunk{
+			Offset:     offset,
+			Size:       int64(n),
+			StrongHash: strongHash(buf[:n], opts),
+		})
+		offset += int64(n)
+
+		if n < len(buf) {
+			break
+		}
+	}
+	return chunks, nil
+}
+
+// neighborRange returns the [lo, hi) bounds of the window of n entries
+// around index i, clamped to [0, n), that MatchingChunks and
+// DiffChunks search for a match: i itself plus up to window entries on
+// either side. window <= 0 disables the restriction and returns the
+// full [0, n) range.
+func neighborRange(n, i, window int) (lo, hi int) {
+	if window <= 0 {
+		return 0, n
+	}
+	lo = i - window
+	if lo < 0 {
+		lo = 0
+	}
+	if lo > n {
+		lo = n
+	}
+	hi = i + window + 1
+	if hi > n {
+		hi = n
+	}
+	return lo, hi
+}
+
+// MatchingChunks compares oldChunks and newChunks by StrongHash and
+// returns the subset of newChunks that already exist among oldChunks,
+// plus the remainder. A newChunk at index i is only compared against
+// oldChunks within neighborWindow entries of i (see neighborRange), on
+// the assumption that a chunk rarely drifts far from its old position
+// between builds - the same locality bsdiff's own windowed diffing
+// relies on - so a large container doesn't pay an O(oldChunks) search
+// per new chunk.
+//
+// This only identifies matches; DiffChunks feeds the unmatched ones
+// through bsdiff.DiffContext.Do.
+func MatchingChunks(oldChunks, newChunks []Chunk, neighborWindow int) (matched, unmatched []Chunk) {
+	for i, c := range newChunks {
+		lo, hi := neighborRange(len(oldChunks), i, neighborWindow)
+
+		found := false
+		for _, oc := range oldChunks[lo:hi] {
+			if bytes.Equal(oc.StrongHash, c.StrongHash) {
+				found = true
+				break
+			}
+		}
+
+		if found {
+			matched = append(matched, c)
+		} else {
+			unmatched = append(unmatched, c)
+		}
+	}
+	return matched, unmatched
+}
@@ -0,0 +1,68 @@
+package tlc
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"math/rand"
+	"testing"
+)
+
+func checkChunksCoverExactly(t *testing.T, data []byte, chunks []Chunk) {
+	t.Helper()
+	var off int64
+	for _, c := range chunks {
+		if c.Offset != off {
+			t.Fatalf("chunk offset %d, want %d", c.Offset, off)
+		}
+		want := sha256.Sum256(data[off : off+c.Size])
+		if !bytes.Equal(c.StrongHash, want[:]) {
+			t.Fatalf("chunk at %d: hash mismatch", off)
+		}
+		off += c.Size
+	}
+	if off != int64(len(data)) {
+		t.Fatalf("chunks cover %d bytes, want %d", off, len(data))
+	}
+}
+
+// TestFastCDCChunksStreaming exercises fastCDCChunks across sizes that
+// land on, just under and just over its min/avg/max boundaries, since
+// it now reads r incrementally rather than taking a full in-memory
+// buffer - a streaming rewrite that doesn't handle a leftover tail or
+// an exact-max read correctly would either drop bytes or mis-tile the
+// chunks it returns.
+func TestFastCDCChunksStreaming(t *testing.T) {
+	opts := &ChunkerOpts{MinChunkSize: 64, AvgChunkSize: 256, MaxChunkSize: 512}
+
+	sizes := []int{0, 1, 63, 64, 255, 256, 511, 512, 513, 10000}
+	rng := rand.New(rand.NewSource(3))
+	for _, n := range sizes {
+		data := make([]byte, n)
+		rng.Read(data)
+		chunks, err := fastCDCChunks(bytes.NewReader(data), opts)
+		if err != nil {
+			t.Fatalf("n=%d: %v", n, err)
+		}
+		checkChunksCoverExactly(t, data, chunks)
+		for _, c := range chunks {
+			if c.Size > opts.MaxChunkSize {
+				t.Fatalf("n=%d: chunk size %d exceeds max %d", n, c.Size, opts.MaxChunkSize)
+			}
+		}
+	}
+}
+
+func TestFixedChunksStreaming(t *testing.T) {
+	opts := &ChunkerOpts{AvgChunkSize: 256}
+	sizes := []int{0, 1, 255, 256, 257, 10000}
+	rng := rand.New(rand.NewSource(4))
+	for _, n := range sizes {
+		data := make([]byte, n)
+		rng.Read(data)
+		chunks, err := fixedChunks(bytes.NewReader(data), opts)
+		if err != nil {
+			t.Fatalf("n=%d: %v", n, err)
+		}
+		checkChunksCoverExactly(t, data, chunks)
+	}
+}
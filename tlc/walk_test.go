@@ -0,0 +1,49 @@
+package tlc
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+func currentGoroutineID() uint64 {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	buf = bytes.TrimPrefix(buf, []byte("goroutine "))
+	buf = buf[:bytes.IndexByte(buf, ' ')]
+	id, _ := strconv.ParseUint(string(buf), 10, 64)
+	return id
+}
+
+// TestWalkDefaultConcurrencyIsSequential guards Walk's backward-compat
+// promise: with zero-value WalkOpts (Concurrency 0), every entry must
+// be visited on the caller's own goroutine, not a spawned one.
+func TestWalkDefaultConcurrencyIsSequential(t *testing.T) {
+	root := t.TempDir()
+	for _, sub := range []string{"a", "a/b", "a/b/c", "d"} {
+		if err := os.MkdirAll(filepath.Join(root, sub), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range []string{"a/f1", "a/b/f2", "a/b/c/f3", "d/f4"} {
+		if err := ioutil.WriteFile(filepath.Join(root, f), []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mainGoroutine := currentGoroutineID()
+	filter := func(fi os.FileInfo) bool {
+		if currentGoroutineID() != mainGoroutine {
+			t.Errorf("%s was visited from a different goroutine - WalkWithOpts isn't walking sequentially", fi.Name())
+		}
+		return true
+	}
+
+	if _, err := Walk(root, filter); err != nil {
+		t.Fatal(err)
+	}
+}